@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"time"
 
 	estype "github.com/elastic/cloud-on-k8s/pkg/apis/elasticsearch/v1beta1"
 	"github.com/elastic/cloud-on-k8s/pkg/controller/common/certificates"
@@ -30,6 +31,7 @@ func (b Builder) CheckK8sTestSteps(k *test.K8sClient) test.StepList {
 	return test.StepList{
 		CheckCertificateAuthority(b, k),
 		CheckExpectedPodsEventuallyReady(b, k),
+		CheckResourcesDeeplyReady(b, k),
 		CheckESVersion(b, k),
 		CheckServices(b, k),
 		CheckPodCertificates(b, k),
@@ -271,6 +273,13 @@ func checkExpectedPodsReady(b Builder, k *test.K8sClient) error {
 			return fmt.Errorf("invalid Pods for StatefulSet %s: expected %v, got %v", statefulSet.Name, expectedPodNames, actualPodNames)
 		}
 
+		// computed once per nodeSet: it fetches every referenced secure settings/podTemplate Secret,
+		// so it must not be recomputed for every Pod in the loop below
+		expectedHash, err := nodeSetHash(k, b.Elasticsearch, nodeSet)
+		if err != nil {
+			return err
+		}
+
 		// all Pods should be running and ready
 		for _, p := range actualPods {
 			if !k8s.IsPodReady(p) {
@@ -287,9 +296,8 @@ func checkExpectedPodsReady(b Builder, k *test.K8sClient) error {
 			// But **not** be annotated with the hash of a different ES spec, meaning
 			// it probably still matches the spec of the pre-mutation builder (rolling upgrade not over).
 			//
-			// Important: this does not catch rolling upgrades due to a keystore change, where the Builder hash
-			// would stay the same.
-			expectedHash := nodeSetHash(b.Elasticsearch, nodeSet)
+			// The hash also folds in the referenced secure settings and podTemplate Secrets, so this
+			// also catches rolling upgrades triggered by a keystore change alone.
 			if p.Annotations[BuilderHashAnnotation] != "" && p.Annotations[BuilderHashAnnotation] != expectedHash {
 				return fmt.Errorf("pod %s was not upgraded (yet?) to match the expected Elasticsearch specification", p.Name)
 			}
@@ -333,8 +341,12 @@ func AnnotatePodsWithBuilderHash(b Builder, k *test.K8sClient) []test.Step {
 					if err != nil {
 						return err
 					}
+					podsHash, err := nodeSetHash(k, es, nodeSet)
+					if err != nil {
+						return err
+					}
 					for i := range pods {
-						pods[i].Annotations[BuilderHashAnnotation] = nodeSetHash(es, nodeSet)
+						pods[i].Annotations[BuilderHashAnnotation] = podsHash
 						if err := k.Client.Update(&pods[i]); err != nil {
 							// may error out with a conflict if concurrently updated by the operator,
 							// which is why we retry with `test.Eventually`
@@ -364,13 +376,124 @@ func AnnotatePodsWithBuilderHash(b Builder, k *test.K8sClient) []test.Step {
 	}
 }
 
-// nodeSetHash builds a hash of the nodeSet specification in the given ES resource.
-func nodeSetHash(es estype.Elasticsearch, nodeSet estype.NodeSet) string {
+// nodeSetHash builds a hash of the nodeSet specification in the given ES resource, plus the
+// ResourceVersion of every Secret that can trigger a rolling upgrade without changing the nodeSet spec
+// itself: the secure settings keystore sources, and any Secret referenced from the nodeSet's Pod
+// template through envFrom or a volume.
+func nodeSetHash(k *test.K8sClient, es estype.Elasticsearch, nodeSet estype.NodeSet) (string, error) {
 	// Normalize the count to zero to exclude it from the hash. Otherwise scaling up/down would affect the hash but
 	// existing nodes not affected by the scaling will not be cycled and therefore be annotated with the previous hash.
 	nodeSet.Count = 0
 	specHash := hash.HashObject(nodeSet)
 	esVersionHash := hash.HashObject(es.Spec.Version)
 	httpServiceHash := hash.HashObject(es.Spec.HTTP)
-	return hash.HashObject(specHash + esVersionHash + httpServiceHash)
+
+	secretsHash, err := referencedSecretsHash(k, es, nodeSet)
+	if err != nil {
+		return "", err
+	}
+
+	return hash.HashObject(specHash + esVersionHash + httpServiceHash + secretsHash), nil
+}
+
+// referencedSecretsHash fetches every Secret referenced from the given ES's secure settings or
+// from the nodeSet's Pod template (envFrom, volumes), and folds their ResourceVersion into a
+// single hash, so that a Secret rotation is reflected even when the nodeSet spec itself is
+// unchanged.
+func referencedSecretsHash(k *test.K8sClient, es estype.Elasticsearch, nodeSet estype.NodeSet) (string, error) {
+	secretNames := make(map[string]struct{})
+	for _, source := range es.Spec.SecureSettings {
+		secretNames[source.SecretName] = struct{}{}
+	}
+	for _, container := range nodeSet.PodTemplate.Spec.Containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				secretNames[envFrom.SecretRef.Name] = struct{}{}
+			}
+		}
+	}
+	for _, volume := range nodeSet.PodTemplate.Spec.Volumes {
+		if volume.Secret != nil {
+			secretNames[volume.Secret.SecretName] = struct{}{}
+		}
+	}
+
+	// sort for a hash that does not depend on map iteration order
+	names := make([]string, 0, len(secretNames))
+	for name := range secretNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resourceVersions := make([]string, 0, len(names))
+	for _, name := range names {
+		var secret corev1.Secret
+		if err := k.Client.Get(types.NamespacedName{Namespace: es.Namespace, Name: name}, &secret); err != nil {
+			return "", err
+		}
+		resourceVersions = append(resourceVersions, name+":"+secret.ResourceVersion)
+	}
+
+	return hash.HashObject(resourceVersions), nil
+}
+
+// CheckKeystoreDrivenRollingUpgrade mutates the given keystore Secret and asserts that every Pod
+// is eventually recycled and re-annotated with a new BuilderHashAnnotation, closing the gap
+// documented on nodeSetHash: a secure settings rotation must trigger a rolling upgrade the same
+// way a spec change does, even though it leaves the nodeSet spec itself untouched.
+//
+// Unlike MutationRollingUpgradeSteps, this step mutates a Secret as part of running it, so it
+// must not be added to a generic check list such as CheckK8sTestSteps. It is expected to be
+// appended to the StepList of a dedicated keystore-rotation e2e test (e.g. a future
+// test/e2e/es/keystore_test.go), which does not exist yet in this tree.
+func CheckKeystoreDrivenRollingUpgrade(b Builder, k *test.K8sClient, secretName string) test.StepList {
+	preMutationHashes := map[string]string{} // Pod name -> BuilderHashAnnotation before the Secret mutation
+	return test.StepList{
+		{
+			Name: "Record Pods' Builder hash before the keystore Secret mutation",
+			Test: test.Eventually(func() error {
+				pods, err := sset.GetActualPodsForCluster(k.Client, b.Elasticsearch)
+				if err != nil {
+					return err
+				}
+				for _, p := range pods {
+					preMutationHashes[p.Name] = p.Annotations[BuilderHashAnnotation]
+				}
+				return nil
+			}),
+		},
+		{
+			Name: fmt.Sprintf("Mutate keystore Secret %s", secretName),
+			Test: test.Eventually(func() error {
+				var secret corev1.Secret
+				if err := k.Client.Get(types.NamespacedName{Namespace: b.Elasticsearch.Namespace, Name: secretName}, &secret); err != nil {
+					return err
+				}
+				if secret.Data == nil {
+					secret.Data = map[string][]byte{}
+				}
+				secret.Data["e2e-rotation-marker"] = []byte(time.Now().String())
+				return k.Client.Update(&secret)
+			}),
+		},
+		{
+			Name: "All Pods should eventually be recycled and re-annotated with the new hash",
+			Test: test.Eventually(func() error {
+				pods, err := sset.GetActualPodsForCluster(k.Client, b.Elasticsearch)
+				if err != nil {
+					return err
+				}
+				for _, p := range pods {
+					newHash := p.Annotations[BuilderHashAnnotation]
+					if newHash == "" {
+						return fmt.Errorf("pod %s is not annotated yet", p.Name)
+					}
+					if newHash == preMutationHashes[p.Name] {
+						return fmt.Errorf("pod %s was not recycled after the keystore Secret mutation", p.Name)
+					}
+				}
+				return nil
+			}),
+		},
+	}
 }
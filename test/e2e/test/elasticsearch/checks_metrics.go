@@ -0,0 +1,224 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package elasticsearch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	estype "github.com/elastic/cloud-on-k8s/pkg/apis/elasticsearch/v1beta1"
+	"github.com/elastic/cloud-on-k8s/test/e2e/test"
+	authv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	ctrlconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// metricsServiceAccountName is the name of the ServiceAccount the metrics auth proxy expects a
+// token for, as set up alongside the metrics sidecar.
+const metricsServiceAccountName = "elasticsearch-metrics"
+
+// clusterServingCAConfigMap is the well-known ConfigMap, populated by the API server in every
+// namespace, that carries the cluster's serving CA bundle. The metrics proxy's certificate is
+// expected to chain to this CA, not to the ES self-signed CA used for transport/HTTP.
+const clusterServingCAConfigMap = "kube-root-ca.crt"
+
+// MetricsService returns the name of the Service exposing the Prometheus-scrapable metrics
+// endpoint for the given Elasticsearch cluster, mirroring estype.HTTPService's naming.
+func MetricsService(esName string) string {
+	return estype.HTTPService(esName) + "-metrics"
+}
+
+// CheckMetricsEndpoint checks that the Prometheus-scrapable metrics endpoint exposed by the
+// metrics sidecar on ES Pods behaves like the auth-proxy pattern we use to bridge Prometheus to a
+// self-signed backend: the Service exists with one endpoint per node, an authenticated scrape
+// succeeds and returns Prometheus exposition format, an unauthenticated scrape is rejected, and
+// the proxy's serving certificate chains to the cluster's serving CA rather than the ES CA.
+//
+// The operator does not create the metrics sidecar, the elasticsearch-metrics ServiceAccount or
+// the metrics Service yet, so this step must not be added to CheckK8sTestSteps until that
+// subsystem ships. It is exported so a metrics-specific e2e test can call it once it does.
+func CheckMetricsEndpoint(b Builder, k *test.K8sClient) test.Step {
+	return test.Step{
+		Name: "ES metrics endpoint should be secure and scrapable",
+		Test: test.Eventually(func() error {
+			serviceName := MetricsService(b.Elasticsearch.Name)
+
+			if err := CheckServiceDeeplyReady(k, b.Elasticsearch.Namespace, serviceName); err != nil {
+				return err
+			}
+
+			expectedAddrCount := int(b.Elasticsearch.Spec.NodeCount())
+			endpoints, err := k.GetEndpoints(b.Elasticsearch.Namespace, serviceName)
+			if err != nil {
+				return err
+			}
+			if len(endpoints.Subsets[0].Addresses) != expectedAddrCount {
+				return fmt.Errorf("%d addresses found for endpoint %s, expected %d", len(endpoints.Subsets[0].Addresses), serviceName, expectedAddrCount)
+			}
+
+			cfg, err := ctrlconfig.GetConfig()
+			if err != nil {
+				return err
+			}
+			clientset, err := kubernetes.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+
+			pods, err := k.GetPods(test.ESPodListOptions(b.Elasticsearch.Namespace, b.Elasticsearch.Name)...)
+			if err != nil {
+				return err
+			}
+			if len(pods) == 0 {
+				return fmt.Errorf("no ES pod found to forward to for the metrics check")
+			}
+			podName := pods[0].Name
+
+			tokenRequest, err := clientset.CoreV1().ServiceAccounts(b.Elasticsearch.Namespace).
+				CreateToken(metricsServiceAccountName, &authv1.TokenRequest{})
+			if err != nil {
+				return err
+			}
+
+			clusterCAs, err := clusterServingCAPool(k, b.Elasticsearch.Namespace)
+			if err != nil {
+				return err
+			}
+
+			body, statusCode, err := scrapeMetrics(cfg, clientset, b.Elasticsearch.Namespace, podName, serviceName, clusterCAs, tokenRequest.Status.Token)
+			if err != nil {
+				return err
+			}
+			if statusCode != http.StatusOK {
+				return fmt.Errorf("metrics endpoint returned status %d with a valid token, expected 200", statusCode)
+			}
+			if !strings.Contains(body, "# HELP") && !strings.Contains(body, "# TYPE") {
+				return fmt.Errorf("metrics endpoint did not return a Prometheus exposition format response")
+			}
+
+			_, unauthStatusCode, err := scrapeMetrics(cfg, clientset, b.Elasticsearch.Namespace, podName, serviceName, clusterCAs, "")
+			if err != nil {
+				return err
+			}
+			if unauthStatusCode != http.StatusUnauthorized && unauthStatusCode != http.StatusForbidden {
+				return fmt.Errorf("unauthenticated metrics call returned status %d, expected 401 or 403", unauthStatusCode)
+			}
+
+			return nil
+		}),
+	}
+}
+
+// clusterServingCAPool loads the cluster's serving CA bundle from the kube-root-ca.crt
+// ConfigMap that the API server maintains in every namespace.
+func clusterServingCAPool(k *test.K8sClient, namespace string) (*x509.CertPool, error) {
+	var cm corev1.ConfigMap
+	if err := k.Client.Get(types.NamespacedName{Namespace: namespace, Name: clusterServingCAConfigMap}, &cm); err != nil {
+		return nil, err
+	}
+	caCert, ok := cm.Data["ca.crt"]
+	if !ok || caCert == "" {
+		return nil, fmt.Errorf("configmap %s: missing or empty ca.crt", clusterServingCAConfigMap)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(caCert)) {
+		return nil, fmt.Errorf("configmap %s: ca.crt does not contain a valid PEM certificate", clusterServingCAConfigMap)
+	}
+	return pool, nil
+}
+
+// scrapeMetrics port-forwards directly to the Pod backing the metrics Service and performs an
+// HTTPS GET of /metrics, validating along the way that the serving certificate is signed by the
+// given CA pool. An empty token issues the request without an Authorization header.
+func scrapeMetrics(cfg *rest.Config, clientset kubernetes.Interface, namespace, podName, serviceName string, clusterCAs *x509.CertPool, token string) (string, int, error) {
+	localPort, closeForwarder, err := forwardToPod(cfg, clientset, namespace, podName, 443)
+	if err != nil {
+		return "", 0, err
+	}
+	defer closeForwarder()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:    clusterCAs,
+				ServerName: fmt.Sprintf("%s.%s.svc", serviceName, namespace),
+			},
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://127.0.0.1:%d/metrics", localPort), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return string(respBody), resp.StatusCode, nil
+}
+
+// forwardToPod opens a port-forward session to the given Pod's remotePort and returns the
+// ephemeral local port it is reachable on, plus a function to tear the session down.
+func forwardToPod(cfg *rest.Config, clientset kubernetes.Interface, namespace, podName string, remotePort int) (int, func(), error) {
+	roundTripper, upgrader, err := spdy.RoundTripperFor(cfg)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	reqURL := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("portforward").
+		URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, reqURL)
+
+	readyCh := make(chan struct{})
+	stopCh := make(chan struct{})
+	errCh := make(chan error, 1)
+
+	fw, err := portforward.New(dialer, []string{fmt.Sprintf("0:%d", remotePort)}, stopCh, readyCh, ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	go func() {
+		errCh <- fw.ForwardPorts()
+	}()
+
+	select {
+	case <-readyCh:
+	case err := <-errCh:
+		return 0, nil, err
+	}
+
+	ports, err := fw.GetPorts()
+	if err != nil {
+		close(stopCh)
+		return 0, nil, err
+	}
+
+	return int(ports[0].Local), func() { close(stopCh) }, nil
+}
@@ -0,0 +1,173 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package elasticsearch
+
+import (
+	"fmt"
+
+	estype "github.com/elastic/cloud-on-k8s/pkg/apis/elasticsearch/v1beta1"
+	"github.com/elastic/cloud-on-k8s/pkg/controller/elasticsearch/sset"
+	"github.com/elastic/cloud-on-k8s/pkg/utils/k8s"
+	"github.com/elastic/cloud-on-k8s/test/e2e/test"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// CheckResourcesDeeplyReady performs a Helm-3-style deep readiness inspection of every resource
+// created for the given Elasticsearch cluster, rather than relying on Pod readiness alone.
+// It catches cases that a plain "Pod is Ready" check misses: a StatefulSet whose status is stale
+// with respect to its spec, a rolling update that is still in progress according to the
+// StatefulSet status, a container that is crash-looping despite currently reporting Ready, or a
+// Service whose Endpoints still carry not-ready addresses.
+func CheckResourcesDeeplyReady(b Builder, k *test.K8sClient) test.Step {
+	restartCounts := map[string]int32{}
+	return test.Step{
+		Name: "ES resources should be deeply ready",
+		Test: test.Eventually(func() error {
+			for _, nodeSet := range b.Elasticsearch.Spec.NodeSets {
+				var statefulSet appsv1.StatefulSet
+				if err := k.Client.Get(
+					types.NamespacedName{
+						Namespace: b.Elasticsearch.Namespace,
+						Name:      estype.StatefulSet(b.Elasticsearch.Name, nodeSet.Name),
+					},
+					&statefulSet,
+				); err != nil {
+					return err
+				}
+				if err := CheckStatefulSetDeeplyReady(statefulSet); err != nil {
+					return err
+				}
+
+				pods, err := sset.GetActualPodsForStatefulSet(k.Client, k8s.ExtractNamespacedName(&statefulSet))
+				if err != nil {
+					return err
+				}
+				for _, p := range pods {
+					if err := CheckPodDeeplyReady(p, restartCounts); err != nil {
+						return err
+					}
+				}
+			}
+
+			if err := CheckServiceDeeplyReady(k, b.Elasticsearch.Namespace, estype.HTTPService(b.Elasticsearch.Name)); err != nil {
+				return err
+			}
+
+			return CheckSecretDeeplyReady(k, b.Elasticsearch.Namespace, fmt.Sprintf("%s-es-elastic-user", b.Elasticsearch.Name), "elastic")
+		}),
+	}
+}
+
+// CheckStatefulSetDeeplyReady requires the StatefulSet status to reflect a fully rolled out,
+// up to date set of replicas, mirroring the checks Helm 3 runs before considering a StatefulSet
+// ready. Exported so Kibana/APM Server builders can reuse the same per-kind check.
+func CheckStatefulSetDeeplyReady(sts appsv1.StatefulSet) error {
+	if sts.Status.ObservedGeneration < sts.Generation {
+		return fmt.Errorf("statefulset %s: status not yet observed (observedGeneration %d, generation %d)",
+			sts.Name, sts.Status.ObservedGeneration, sts.Generation)
+	}
+
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	if sts.Status.ReadyReplicas != replicas || sts.Status.CurrentReplicas != replicas || sts.Status.UpdatedReplicas != replicas {
+		return fmt.Errorf("statefulset %s: expected %d replicas, got ready=%d current=%d updated=%d",
+			sts.Name, replicas, sts.Status.ReadyReplicas, sts.Status.CurrentReplicas, sts.Status.UpdatedReplicas)
+	}
+
+	strategy := sts.Spec.UpdateStrategy
+	if strategy.Type == appsv1.RollingUpdateStatefulSetStrategyType && strategy.RollingUpdate != nil && strategy.RollingUpdate.Partition != nil {
+		partition := *strategy.RollingUpdate.Partition
+		if sts.Status.UpdatedReplicas < replicas-partition {
+			return fmt.Errorf("statefulset %s: expected at least %d updated replicas given partition %d, got %d",
+				sts.Name, replicas-partition, partition, sts.Status.UpdatedReplicas)
+		}
+		if sts.Status.CurrentRevision != sts.Status.UpdateRevision {
+			return fmt.Errorf("statefulset %s: rolling update not over, currentRevision %s != updateRevision %s",
+				sts.Name, sts.Status.CurrentRevision, sts.Status.UpdateRevision)
+		}
+	}
+
+	return nil
+}
+
+// CheckPodDeeplyReady requires every container to be individually Ready, its restart count to be
+// stable across two consecutive polls, and every init container to have completed successfully.
+// restartCounts is keyed by "podName/containerName" and is expected to be reused across polls by
+// the caller, so a restart count can be compared against the previous observation. Exported so
+// Kibana/APM Server builders can reuse the same per-kind check.
+func CheckPodDeeplyReady(p corev1.Pod, restartCounts map[string]int32) error {
+	if !k8s.IsPodReady(p) {
+		return fmt.Errorf("pod %s is not Ready", p.Name)
+	}
+
+	for _, cs := range p.Status.ContainerStatuses {
+		if !cs.Ready {
+			return fmt.Errorf("pod %s: container %s is not Ready", p.Name, cs.Name)
+		}
+		key := p.Name + "/" + cs.Name
+		previous, seen := restartCounts[key]
+		restartCounts[key] = cs.RestartCount
+		if !seen {
+			return fmt.Errorf("pod %s: container %s restart count not yet stable across two polls", p.Name, cs.Name)
+		}
+		if previous != cs.RestartCount {
+			return fmt.Errorf("pod %s: container %s restart count changed from %d to %d between polls", p.Name, cs.Name, previous, cs.RestartCount)
+		}
+	}
+
+	for _, cs := range p.Status.InitContainerStatuses {
+		if cs.State.Terminated == nil || cs.State.Terminated.Reason != "Completed" {
+			return fmt.Errorf("pod %s: init container %s has not completed", p.Name, cs.Name)
+		}
+	}
+
+	return nil
+}
+
+// CheckServiceDeeplyReady requires the named Service to exist and its Endpoints to only carry
+// ready addresses (no NotReadyAddresses left over from a draining or not-yet-ready Pod). Exported
+// so Kibana/APM Server builders can reuse the same per-kind check.
+func CheckServiceDeeplyReady(k *test.K8sClient, namespace, name string) error {
+	if _, err := k.GetService(namespace, name); err != nil {
+		return err
+	}
+
+	endpoints, err := k.GetEndpoints(namespace, name)
+	if err != nil {
+		return err
+	}
+	if len(endpoints.Subsets) == 0 {
+		return fmt.Errorf("service %s: no endpoint subset", name)
+	}
+	for _, subset := range endpoints.Subsets {
+		if len(subset.NotReadyAddresses) > 0 {
+			return fmt.Errorf("service %s: %d not-ready addresses", name, len(subset.NotReadyAddresses))
+		}
+		if len(subset.Addresses) == 0 {
+			return fmt.Errorf("service %s: no ready addresses", name)
+		}
+	}
+
+	return nil
+}
+
+// CheckSecretDeeplyReady requires the named Secret to exist and carry non-empty values for every
+// one of requiredKeys. Exported so Kibana/APM Server builders can reuse the same per-kind check.
+func CheckSecretDeeplyReady(k *test.K8sClient, namespace, name string, requiredKeys ...string) error {
+	var secret corev1.Secret
+	if err := k.Client.Get(types.NamespacedName{Namespace: namespace, Name: name}, &secret); err != nil {
+		return err
+	}
+	for _, key := range requiredKeys {
+		if len(secret.Data[key]) == 0 {
+			return fmt.Errorf("secret %s: missing or empty key %s", name, key)
+		}
+	}
+	return nil
+}
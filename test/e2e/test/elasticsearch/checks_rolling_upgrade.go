@@ -0,0 +1,143 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package elasticsearch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	estype "github.com/elastic/cloud-on-k8s/pkg/apis/elasticsearch/v1beta1"
+	"github.com/elastic/cloud-on-k8s/pkg/controller/elasticsearch/sset"
+	"github.com/elastic/cloud-on-k8s/pkg/utils/k8s"
+	"github.com/elastic/cloud-on-k8s/test/e2e/test"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// CheckOrderedRollingUpgrade returns a step that continuously samples Pods while a mutation is
+// rolling out, and asserts the invariants a StatefulSet-based rolling upgrade must satisfy:
+//   - within each nodeSet's StatefulSet, Pods are terminated/recreated strictly in descending
+//     ordinal order;
+//   - at any point in time, at most one Pod per StatefulSet is not Ready because of the upgrade;
+//   - Pods with an ordinal below the StatefulSet's partition keep their pre-mutation revision
+//     until the upgrade is over.
+//
+// Unlike checkExpectedPodsReady, which only inspects the terminal state, this step is meant to be
+// run concurrently with the mutation itself, so it can catch an operator regression where nodes
+// are cycled out of order or where a rolling change silently degrades into a mass restart.
+func CheckOrderedRollingUpgrade(b Builder, k *test.K8sClient) test.Step {
+	preMutationRevisions := map[string]string{} // Pod name -> controller-revision-hash, recorded the first time we see that Pod
+	return test.Step{
+		Name: "Rolling upgrade should respect StatefulSet ordinal ordering",
+		Test: test.Eventually(func() error {
+			for _, nodeSet := range b.Elasticsearch.Spec.NodeSets {
+				var statefulSet appsv1.StatefulSet
+				if err := k.Client.Get(
+					types.NamespacedName{
+						Namespace: b.Elasticsearch.Namespace,
+						Name:      estype.StatefulSet(b.Elasticsearch.Name, nodeSet.Name),
+					},
+					&statefulSet,
+				); err != nil {
+					return err
+				}
+
+				pods, err := sset.GetActualPodsForStatefulSet(k.Client, k8s.ExtractNamespacedName(&statefulSet))
+				if err != nil {
+					return err
+				}
+
+				if err := checkOrdinalOrdering(statefulSet, pods, preMutationRevisions); err != nil {
+					return err
+				}
+
+				for _, p := range pods {
+					if _, recorded := preMutationRevisions[p.Name]; !recorded {
+						preMutationRevisions[p.Name] = p.Labels[appsv1.ControllerRevisionHashLabelKey]
+					}
+				}
+			}
+			return nil
+		}),
+	}
+}
+
+// MutationRollingUpgradeSteps bundles the steps a mutation e2e test should run once it has
+// applied a new spec: record the Pods' pre-mutation Builder hash (AnnotatePodsWithBuilderHash),
+// then continuously sample Pods while the mutation rolls out (CheckOrderedRollingUpgrade). This
+// is expected to be inserted by the mutation test driver (e.g. test/e2e/es/mutation_test.go's
+// RunMutation) between applying the new spec and waiting on the post-mutation
+// CheckK8sTestSteps.
+func MutationRollingUpgradeSteps(b Builder, k *test.K8sClient) test.StepList {
+	return append(AnnotatePodsWithBuilderHash(b, k), CheckOrderedRollingUpgrade(b, k))
+}
+
+// checkOrdinalOrdering compares the controller-revision-hash of each Pod against the revision
+// recorded the first time it was observed (history), and enforces that Pods are only recycled in
+// descending ordinal order, that Pods below the partition are not prematurely upgraded, and that
+// no more than one Pod is unready at once.
+func checkOrdinalOrdering(sts appsv1.StatefulSet, pods []corev1.Pod, history map[string]string) error {
+	partition := int32(0)
+	if sts.Spec.UpdateStrategy.RollingUpdate != nil && sts.Spec.UpdateStrategy.RollingUpdate.Partition != nil {
+		partition = *sts.Spec.UpdateStrategy.RollingUpdate.Partition
+	}
+	rollingUpgradeOngoing := sts.Status.CurrentRevision != sts.Status.UpdateRevision
+
+	notReady := 0
+	for _, p := range pods {
+		ordinal, err := podOrdinal(sts.Name, p.Name)
+		if err != nil {
+			return err
+		}
+		revision := p.Labels[appsv1.ControllerRevisionHashLabelKey]
+
+		if int32(ordinal) < partition && rollingUpgradeOngoing && revision == sts.Status.UpdateRevision {
+			return fmt.Errorf("pod %s (ordinal %d) was upgraded despite being below partition %d", p.Name, ordinal, partition)
+		}
+
+		previous, known := history[p.Name]
+		if known && previous != revision {
+			// this Pod was just recycled: StatefulSets roll Pods out in descending ordinal order,
+			// so every higher-ordinal Pod must already have been recycled too.
+			for _, other := range pods {
+				otherOrdinal, err := podOrdinal(sts.Name, other.Name)
+				if err != nil {
+					return err
+				}
+				if otherOrdinal <= ordinal {
+					continue
+				}
+				otherPrevious, otherKnown := history[other.Name]
+				if otherKnown && otherPrevious == other.Labels[appsv1.ControllerRevisionHashLabelKey] {
+					return fmt.Errorf("pod %s (ordinal %d) was recycled before higher ordinal pod %s (ordinal %d)",
+						p.Name, ordinal, other.Name, otherOrdinal)
+				}
+			}
+		}
+
+		if !k8s.IsPodReady(p) {
+			notReady++
+		}
+	}
+
+	if notReady > 1 {
+		return fmt.Errorf("statefulset %s: %d Pods not Ready at once, expected at most 1 during a rolling upgrade", sts.Name, notReady)
+	}
+
+	return nil
+}
+
+// podOrdinal extracts the ordinal suffix ("-N") from a Pod name built from the given StatefulSet
+// name.
+func podOrdinal(statefulSetName, podName string) (int, error) {
+	suffix := strings.TrimPrefix(podName, statefulSetName+"-")
+	ordinal, err := strconv.Atoi(suffix)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse ordinal from pod name %s: %w", podName, err)
+	}
+	return ordinal, nil
+}